@@ -0,0 +1,209 @@
+package filedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// BSONCodec stores values using the BSON binary document format
+// (https://bsonspec.org/). Like MessagePackCodec, Marshal and Unmarshal
+// round-trip v through encoding/json's generic representation to get
+// struct-tag-aware decoding for free, then encode or decode that
+// generic tree directly as BSON bytes on the wire. BSON documents are
+// top-level key/value maps, so v (or whatever it marshals to as JSON)
+// must itself be a JSON object. BSON output is binary and may contain
+// newline bytes, so records are length-prefixed rather than
+// newline-terminated.
+type BSONCodec struct{}
+
+// Marshal encodes v as a BSON document.
+func (BSONCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("filedb: bson: value must marshal to a JSON object: %w", err)
+	}
+	return bsonEncodeDocument(doc)
+}
+
+// Unmarshal decodes BSON data into v.
+func (BSONCodec) Unmarshal(data []byte, v interface{}) error {
+	doc, _, err := bsonDecodeDocument(data)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Ext returns the file extension used for BSON-encoded collections.
+func (BSONCodec) Ext() string { return ".bsondb" }
+
+// Framed returns true; BSON output is binary.
+func (BSONCodec) Framed() bool { return true }
+
+const (
+	bsonTypeDouble   = 0x01
+	bsonTypeString   = 0x02
+	bsonTypeDocument = 0x03
+	bsonTypeArray    = 0x04
+	bsonTypeBoolean  = 0x08
+	bsonTypeNull     = 0x0A
+)
+
+func bsonEncodeDocument(doc map[string]interface{}) ([]byte, error) {
+	var body bytes.Buffer
+	for k, v := range doc {
+		eb, err := bsonEncodeElement(k, v)
+		if err != nil {
+			return nil, err
+		}
+		body.Write(eb)
+	}
+	body.WriteByte(0)
+
+	total := int32(4 + body.Len())
+	out := make([]byte, 4, total)
+	binary.LittleEndian.PutUint32(out, uint32(total))
+	out = append(out, body.Bytes()...)
+	return out, nil
+}
+
+func bsonEncodeElement(name string, v interface{}) ([]byte, error) {
+	cname := append([]byte(name), 0)
+	switch val := v.(type) {
+	case nil:
+		return append([]byte{bsonTypeNull}, cname...), nil
+	case bool:
+		b := byte(0)
+		if val {
+			b = 1
+		}
+		return append(append([]byte{bsonTypeBoolean}, cname...), b), nil
+	case float64:
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(val))
+		return append(append([]byte{bsonTypeDouble}, cname...), b[:]...), nil
+	case string:
+		strBytes := append([]byte(val), 0)
+		var lenBuf [4]byte
+		binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(strBytes)))
+		out := append([]byte{bsonTypeString}, cname...)
+		out = append(out, lenBuf[:]...)
+		out = append(out, strBytes...)
+		return out, nil
+	case map[string]interface{}:
+		sub, err := bsonEncodeDocument(val)
+		if err != nil {
+			return nil, err
+		}
+		return append(append([]byte{bsonTypeDocument}, cname...), sub...), nil
+	case []interface{}:
+		m := make(map[string]interface{}, len(val))
+		for i, e := range val {
+			m[strconv.Itoa(i)] = e
+		}
+		sub, err := bsonEncodeDocument(m)
+		if err != nil {
+			return nil, err
+		}
+		return append(append([]byte{bsonTypeArray}, cname...), sub...), nil
+	default:
+		return nil, fmt.Errorf("filedb: bson: unsupported value type %T", v)
+	}
+}
+
+// bsonDecodeDocument decodes a single BSON document from the front of
+// data, returning the decoded fields and the number of bytes consumed.
+func bsonDecodeDocument(data []byte) (map[string]interface{}, int, error) {
+	if len(data) < 5 {
+		return nil, 0, fmt.Errorf("filedb: bson: truncated document")
+	}
+	total := int(int32(binary.LittleEndian.Uint32(data[:4])))
+	if total < 5 || total > len(data) {
+		return nil, 0, fmt.Errorf("filedb: bson: declared length %d exceeds buffer of %d bytes", total, len(data))
+	}
+	body := data[4 : total-1]
+
+	doc := make(map[string]interface{})
+	pos := 0
+	for pos < len(body) {
+		typ := body[pos]
+		pos++
+		name, n, err := bsonReadCString(body[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+		pos += n
+		val, adv, err := bsonDecodeValue(typ, body[pos:])
+		if err != nil {
+			return nil, 0, err
+		}
+		pos += adv
+		doc[name] = val
+	}
+	return doc, total, nil
+}
+
+func bsonDecodeValue(typ byte, data []byte) (interface{}, int, error) {
+	switch typ {
+	case bsonTypeDouble:
+		if len(data) < 8 {
+			return nil, 0, fmt.Errorf("filedb: bson: truncated double")
+		}
+		bits := binary.LittleEndian.Uint64(data[:8])
+		return math.Float64frombits(bits), 8, nil
+	case bsonTypeString:
+		if len(data) < 4 {
+			return nil, 0, fmt.Errorf("filedb: bson: truncated string length")
+		}
+		l := int(int32(binary.LittleEndian.Uint32(data[:4])))
+		if l < 1 || 4+l > len(data) {
+			return nil, 0, fmt.Errorf("filedb: bson: truncated string")
+		}
+		return string(data[4 : 4+l-1]), 4 + l, nil
+	case bsonTypeBoolean:
+		if len(data) < 1 {
+			return nil, 0, fmt.Errorf("filedb: bson: truncated boolean")
+		}
+		return data[0] != 0, 1, nil
+	case bsonTypeNull:
+		return nil, 0, nil
+	case bsonTypeDocument:
+		sub, n, err := bsonDecodeDocument(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		return sub, n, nil
+	case bsonTypeArray:
+		sub, n, err := bsonDecodeDocument(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		arr := make([]interface{}, len(sub))
+		for i := range arr {
+			arr[i] = sub[strconv.Itoa(i)]
+		}
+		return arr, n, nil
+	default:
+		return nil, 0, fmt.Errorf("filedb: bson: unsupported element type 0x%02x", typ)
+	}
+}
+
+func bsonReadCString(data []byte) (string, int, error) {
+	idx := bytes.IndexByte(data, 0)
+	if idx < 0 {
+		return "", 0, fmt.Errorf("filedb: bson: unterminated field name")
+	}
+	return string(data[:idx]), idx + 1, nil
+}
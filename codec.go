@@ -0,0 +1,66 @@
+package filedb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec describes an encoding used to store and retrieve values in a
+// collection opened with CWithCodec. Codecs whose encoded output is not
+// guaranteed to be free of newlines (binary codecs) must report true
+// from Framed so records are length-prefixed instead of
+// newline-terminated.
+type Codec interface {
+	// Marshal encodes v into its on-disk representation.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data, as produced by Marshal, into v.
+	Unmarshal(data []byte, v interface{}) error
+	// Ext is the file extension used for collections stored with this
+	// codec, e.g. ".bsondb".
+	Ext() string
+	// Framed reports whether records require length-prefixed framing
+	// rather than newline-terminated lines.
+	Framed() bool
+}
+
+// JSONCodec is the Codec used by collections opened with C; it matches
+// the behaviour InsertJSON has always had.
+type JSONCodec struct{}
+
+// Marshal encodes v as JSON.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal decodes JSON data into v.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// Ext returns Ext, the standard filedb extension.
+func (JSONCodec) Ext() string { return Ext }
+
+// Framed returns false; JSON output never contains raw newlines.
+func (JSONCodec) Framed() bool { return false }
+
+// GobCodec stores values using encoding/gob. gob output is binary and
+// may contain newline bytes, so records are length-prefixed rather than
+// newline-terminated.
+type GobCodec struct{}
+
+// Marshal encodes v using encoding/gob.
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes gob data into v.
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Ext returns the file extension used for gob-encoded collections.
+func (GobCodec) Ext() string { return ".gobdb" }
+
+// Framed returns true; gob output is binary.
+func (GobCodec) Framed() bool { return true }
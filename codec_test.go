@@ -0,0 +1,108 @@
+package filedb_test
+
+import (
+	"testing"
+
+	"github.com/matryer/filedb"
+	"github.com/stretchr/testify/require"
+)
+
+type codecPerson struct {
+	Name     string
+	Location string
+}
+
+func TestCWithCodecGob(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.CWithCodec("TestCWithCodecGob", filedb.GobCodec{})
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+	require.Equal(t, "test/db/TestCWithCodecGob.gobdb", c.Path())
+
+	require.NoError(t, c.InsertValue(codecPerson{Name: "Mat", Location: "San Francisco"}))
+	require.NoError(t, c.InsertValue(codecPerson{Name: "Ryan", Location: "Boulder"}))
+
+	var people []codecPerson
+	err = c.ForEachValue(func(i int, decode func(v interface{}) error) bool {
+		var p codecPerson
+		require.NoError(t, decode(&p))
+		people = append(people, p)
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(people))
+	require.Equal(t, "Mat", people[0].Name)
+	require.Equal(t, "Ryan", people[1].Name)
+
+}
+
+func TestCWithCodecBSON(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.CWithCodec("TestCWithCodecBSON", filedb.BSONCodec{})
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+	require.Equal(t, "test/db/TestCWithCodecBSON.bsondb", c.Path())
+
+	require.NoError(t, c.InsertValue(codecPerson{Name: "Mat", Location: "San Francisco"}))
+	require.NoError(t, c.InsertValue(codecPerson{Name: "Ryan", Location: "Boulder"}))
+
+	var people []codecPerson
+	err = c.ForEachValue(func(i int, decode func(v interface{}) error) bool {
+		var p codecPerson
+		require.NoError(t, decode(&p))
+		people = append(people, p)
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(people))
+	require.Equal(t, "Mat", people[0].Name)
+	require.Equal(t, "Ryan", people[1].Name)
+
+}
+
+func TestCWithCodecMessagePack(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.CWithCodec("TestCWithCodecMessagePack", filedb.MessagePackCodec{})
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+	require.Equal(t, "test/db/TestCWithCodecMessagePack.msgpackdb", c.Path())
+
+	require.NoError(t, c.InsertValue(codecPerson{Name: "Mat", Location: "San Francisco"}))
+	require.NoError(t, c.InsertValue(codecPerson{Name: "Ryan", Location: "Boulder"}))
+
+	var people []codecPerson
+	err = c.ForEachValue(func(i int, decode func(v interface{}) error) bool {
+		var p codecPerson
+		require.NoError(t, decode(&p))
+		people = append(people, p)
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(people))
+	require.Equal(t, "Mat", people[0].Name)
+	require.Equal(t, "Ryan", people[1].Name)
+
+}
+
+func TestCWithCodecSameCollection(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	c1, err := db.CWithCodec("TestCWithCodecSame", filedb.GobCodec{})
+	require.NoError(t, err)
+	c2, err := db.CWithCodec("TestCWithCodecSame", filedb.GobCodec{})
+	require.NoError(t, err)
+	require.Equal(t, c1, c2, "Cs with same name and codec should be same object")
+
+}
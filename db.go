@@ -2,9 +2,11 @@ package filedb
 
 import (
 	"bufio"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -30,10 +32,15 @@ var (
 type DB struct {
 	path string
 	cs   map[string]*C
+
+	maxSegmentBytes     int64
+	autoCompactSegments int
 }
 
-// Dial initiates communication with a database.
-func Dial(d string) (*DB, error) {
+// Dial initiates communication with a database. opts configure
+// behaviour such as segment rotation that applies to every collection
+// opened from the returned DB.
+func Dial(d string, opts ...Option) (*DB, error) {
 	var err error
 	var i os.FileInfo
 	if i, err = os.Stat(d); os.IsNotExist(err) {
@@ -42,7 +49,14 @@ func Dial(d string) (*DB, error) {
 	if !i.IsDir() {
 		return nil, ErrDBNotFound
 	}
-	return &DB{path: d, cs: make(map[string]*C)}, nil
+	if err := recoverIntentLogs(d); err != nil {
+		return nil, err
+	}
+	db := &DB{path: d, cs: make(map[string]*C)}
+	for _, opt := range opts {
+		opt(db)
+	}
+	return db, nil
 }
 
 // ColNames gets a list of all collections in the
@@ -79,12 +93,39 @@ func (db *DB) C(name string) (*C, error) {
 	return c, nil
 }
 
+// CWithCodec is like C but stores the collection's records using codec
+// instead of the default JSON encoding. The collection file is named
+// using codec.Ext() rather than Ext, so collections using different
+// codecs never collide on disk.
+func (db *DB) CWithCodec(name string, codec Codec) (*C, error) {
+	key := name + codec.Ext()
+	if c, ok := db.cs[key]; ok {
+		return c, nil
+	}
+	c := &C{db: db, path: filepath.Join(db.path, name+codec.Ext()), codec: codec}
+	db.cs[key] = c
+	return c, nil
+}
+
 // C represents a collection of JSON objects.
 type C struct {
-	db   *DB
-	path string
-	m    sync.Mutex
-	f    *os.File
+	db      *DB
+	path    string
+	codec   Codec
+	indexes map[string]*Index
+	m       sync.Mutex
+	f       *os.File
+
+	activeSegNum int
+}
+
+// codecFor returns the Codec used by this collection, defaulting to
+// JSONCodec for collections opened with C rather than CWithCodec.
+func (c *C) codecFor() Codec {
+	if c.codec == nil {
+		return JSONCodec{}
+	}
+	return c.codec
 }
 
 // Path gets the full filepath of the storage for this
@@ -98,6 +139,10 @@ func (c *C) DB() *DB {
 	return c.db
 }
 func (c *C) close() {
+	// Best-effort: a failed flush here just means a batched index
+	// sidecar lags the data file until it's next detected as stale and
+	// rebuilt from a scan (see indexFlushInterval), not lost data.
+	c.flushIndexesLocked()
 	if c.f != nil {
 		c.f.Close()
 		c.f = nil
@@ -132,14 +177,21 @@ func (c *C) Drop() error {
 func (c *C) Insert(o []byte) error {
 	c.m.Lock()
 	defer c.m.Unlock()
+	if c.segmented() {
+		return c.insertSegmentedLocked(o)
+	}
 	f, err := c.file()
 	if err != nil {
 		return err
 	}
-	f.Seek(0, os.SEEK_END)
-	f.Write(o)
-	f.WriteString(fmt.Sprintln())
-	return nil
+	offset, err := f.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+	if err := c.writeRecord(f, o); err != nil {
+		return err
+	}
+	return c.indexRecordLocked(offset, o)
 }
 
 // InsertJSON inserts a JSON encoded version of the specified
@@ -152,6 +204,87 @@ func (c *C) InsertJSON(obj interface{}) error {
 	return c.Insert(b)
 }
 
+// InsertValue encodes v using the collection's Codec and inserts the
+// result. For collections opened with C (rather than CWithCodec) this
+// behaves like InsertJSON.
+func (c *C) InsertValue(v interface{}) error {
+	b, err := c.codecFor().Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Insert(b)
+}
+
+// writeRecord writes data to f using the framing required by the
+// collection's Codec: newline-terminated for text-safe codecs (the
+// default), or length-prefixed for codecs whose output may itself
+// contain newlines.
+func (c *C) writeRecord(f *os.File, data []byte) error {
+	if c.codecFor().Framed() {
+		var lenBuf [4]byte
+		binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+		if _, err := f.Write(lenBuf[:]); err != nil {
+			return err
+		}
+		_, err := f.Write(data)
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	_, err := f.WriteString(fmt.Sprintln())
+	return err
+}
+
+// recordScanner is satisfied by bufio.Scanner and lets SelectEach/ForEach
+// read records without caring whether they're newline-terminated or
+// length-prefixed.
+type recordScanner interface {
+	Scan() bool
+	Bytes() []byte
+	Err() error
+}
+
+// scanner returns the recordScanner appropriate for the collection's Codec.
+func (c *C) scanner(f *os.File) recordScanner {
+	if c.codecFor().Framed() {
+		return newFramedScanner(f)
+	}
+	return bufio.NewScanner(f)
+}
+
+// framedScanner reads length-prefixed records, as written by writeRecord
+// for Codecs whose Framed method returns true.
+type framedScanner struct {
+	r   io.Reader
+	buf []byte
+	err error
+}
+
+func newFramedScanner(r io.Reader) *framedScanner {
+	return &framedScanner{r: r}
+}
+
+func (s *framedScanner) Scan() bool {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(s.r, lenBuf[:]); err != nil {
+		if err != io.EOF {
+			s.err = err
+		}
+		return false
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+	if _, err := io.ReadFull(s.r, buf); err != nil {
+		s.err = err
+		return false
+	}
+	s.buf = buf
+	return true
+}
+
+func (s *framedScanner) Bytes() []byte { return s.buf }
+func (s *framedScanner) Err() error    { return s.err }
+
 // SelectEach calls fn for each item in the collection replacing the
 // data if include is true.
 //     c.SelectEach(func(i int, data []byte) {
@@ -164,6 +297,9 @@ func (c *C) InsertJSON(obj interface{}) error {
 func (c *C) SelectEach(fn func(int, []byte) (include bool, data []byte, stop bool)) error {
 	c.m.Lock()
 	defer c.m.Unlock()
+	if c.segmented() {
+		return c.selectEachSegmentedLocked(fn)
+	}
 
 	// make temp file
 	tempfile, err := ioutil.TempFile(filepath.Dir(c.path), "filedb")
@@ -180,16 +316,29 @@ func (c *C) SelectEach(fn func(int, []byte) (include bool, data []byte, stop boo
 		return err
 	}
 	f.Seek(0, os.SEEK_SET)
-	s := bufio.NewScanner(f)
+	s := c.scanner(f)
 	i := 0
+	var offset int64
+	newIndexes := make(map[string]*Index, len(c.indexes))
+	for name, idx := range c.indexes {
+		newIndexes[name] = &Index{name: name, keyFunc: idx.keyFunc, offsets: make(map[string][]int64)}
+	}
 	for s.Scan() {
 		include, data, stop := fn(i, s.Bytes())
 		if include {
-			tempfile.Write(data)
-			_, err := tempfile.Write([]byte("\n"))
-			if err != nil {
+			for _, idx := range newIndexes {
+				key, err := idx.keyFunc(data)
+				if err != nil {
+					return err
+				}
+				if key != nil {
+					idx.offsets[string(key)] = append(idx.offsets[string(key)], offset)
+				}
+			}
+			if err := c.writeRecord(tempfile, data); err != nil {
 				return err
 			}
+			offset += c.recordSize(data)
 		}
 		if stop {
 			break
@@ -205,6 +354,12 @@ func (c *C) SelectEach(fn func(int, []byte) (include bool, data []byte, stop boo
 	if err != nil {
 		return err
 	}
+	for name, idx := range newIndexes {
+		c.indexes[name] = idx
+		if err := c.saveIndexLocked(idx); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -214,12 +369,15 @@ func (c *C) SelectEach(fn func(int, []byte) (include bool, data []byte, stop boo
 func (c *C) ForEach(fn func(int, []byte) bool) error {
 	c.m.Lock()
 	defer c.m.Unlock()
+	if c.segmented() {
+		return c.forEachSegmentedLocked(fn)
+	}
 	f, err := c.file()
 	if err != nil {
 		return err
 	}
 	f.Seek(0, os.SEEK_SET)
-	s := bufio.NewScanner(f)
+	s := c.scanner(f)
 	i := 0
 	for s.Scan() {
 		if fn(i, s.Bytes()) {
@@ -233,11 +391,34 @@ func (c *C) ForEach(fn func(int, []byte) bool) error {
 	return nil
 }
 
+// ForEachValue is like ForEach, but decodes each record using the
+// collection's Codec before handing it to fn. fn calls the provided
+// decode function with a destination to populate; decode may be called
+// at most once per record.
+func (c *C) ForEachValue(fn func(int, func(v interface{}) error) bool) error {
+	codec := c.codecFor()
+	return c.ForEach(func(i int, data []byte) bool {
+		return fn(i, func(v interface{}) error {
+			return codec.Unmarshal(data, v)
+		})
+	})
+}
+
 // RemoveEach calls fn for each record in the collection, removing any
 // for which fn returns true.
 // If stop is returned, processing ceases after the current record has
 // been processed.
+//
+// For collections with segment rotation enabled, a removal flips a
+// tombstone flag in place rather than rewriting a file; call Compact
+// to reclaim the space. Collections without segment rotation still
+// remove by rewriting the whole file, as SelectEach always has.
 func (c *C) RemoveEach(fn func(int, []byte) (bool, bool)) error {
+	if c.segmented() {
+		c.m.Lock()
+		defer c.m.Unlock()
+		return c.removeEachSegmentedLocked(fn)
+	}
 	return c.SelectEach(func(i int, data []byte) (bool, []byte, bool) {
 		remove, stop := fn(i, data)
 		return !remove, data, stop
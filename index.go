@@ -0,0 +1,312 @@
+package filedb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// indexFlushInterval is the number of inserts an index may accumulate
+// before indexRecordLocked persists it to its sidecar file, amortizing
+// the cost of the gob-encode-and-rename across many inserts instead of
+// paying it on every single one. A crash before a flush only costs the
+// unpersisted inserts: loadIndexLocked already detects a sidecar whose
+// header no longer matches the data file's size/mtime as stale and
+// rebuilds the index from a full scan, so batching the persist never
+// risks serving stale offsets, only delays how soon the sidecar itself
+// catches up.
+const indexFlushInterval = 100
+
+// Index maintains a mapping from a derived key to the byte offsets of
+// matching records within a collection's data file, so FindByIndex can
+// seek directly to matches instead of scanning every record.
+type Index struct {
+	name    string
+	keyFunc func(raw []byte) ([]byte, error)
+	offsets map[string][]int64
+
+	dirty       bool
+	dirtyWrites int
+}
+
+// indexHeader records the state of the data file an index sidecar was
+// built against, so a stale sidecar (one left behind after the data
+// file changed some other way) can be detected and discarded.
+type indexHeader struct {
+	DataSize    int64
+	DataModTime int64
+}
+
+// indexFile is the on-disk (gob-encoded) representation of an index
+// sidecar.
+type indexFile struct {
+	Header  indexHeader
+	Offsets map[string][]int64
+}
+
+// indexPath returns the sidecar file path for the named index, e.g.
+// "TestDB.filedb.idx.name".
+func (c *C) indexPath(name string) string {
+	return fmt.Sprintf("%s.idx.%s", c.path, name)
+}
+
+// EnsureIndex registers a named index on the collection, deriving each
+// record's key with keyFunc (a nil key excludes the record from the
+// index). If a valid sidecar already exists on disk it is loaded;
+// otherwise the index is built from a full scan and persisted.
+//     c.EnsureIndex("name", func(raw []byte) ([]byte, error) {
+//         var v struct{ Name string }
+//         if err := json.Unmarshal(raw, &v); err != nil {
+//             return nil, err
+//         }
+//         return []byte(v.Name), nil
+//     })
+func (c *C) EnsureIndex(name string, keyFunc func(raw []byte) ([]byte, error)) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.segmented() {
+		return fmt.Errorf("filedb: indexes are not supported on collections with segment rotation enabled")
+	}
+	if c.indexes == nil {
+		c.indexes = make(map[string]*Index)
+	}
+	loaded, ok, err := c.loadIndexLocked(name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		loaded.keyFunc = keyFunc
+		c.indexes[name] = loaded
+		return nil
+	}
+	idx := &Index{name: name, keyFunc: keyFunc, offsets: make(map[string][]int64)}
+	if err := c.buildIndexLocked(idx); err != nil {
+		return err
+	}
+	c.indexes[name] = idx
+	return c.saveIndexLocked(idx)
+}
+
+// FindByIndex calls fn for each record whose key (as derived by the
+// keyFunc passed to EnsureIndex) equals key, seeking directly to each
+// match. EnsureIndex must have been called for name first.
+func (c *C) FindByIndex(name string, key []byte, fn func(i int, data []byte) bool) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	idx, ok := c.indexes[name]
+	if !ok {
+		return fmt.Errorf("filedb: no such index %q", name)
+	}
+	f, err := c.file()
+	if err != nil {
+		return err
+	}
+	for i, offset := range idx.offsets[string(key)] {
+		data, err := c.readRecordAt(f, offset)
+		if err != nil {
+			return err
+		}
+		if fn(i, data) {
+			break
+		}
+	}
+	return nil
+}
+
+// RebuildIndexes reloads every registered index from its sidecar file,
+// rebuilding from a full scan of the collection for any index whose
+// sidecar is missing or stale (its recorded data file size/mtime no
+// longer match the collection file on disk). Call this on startup if a
+// crash may have left a sidecar out of sync with its data file.
+func (c *C) RebuildIndexes() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for name, idx := range c.indexes {
+		loaded, ok, err := c.loadIndexLocked(name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			idx.offsets = loaded.offsets
+			continue
+		}
+		idx.offsets = make(map[string][]int64)
+		if err := c.buildIndexLocked(idx); err != nil {
+			return err
+		}
+		if err := c.saveIndexLocked(idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexRecordLocked adds offset (the position at which data was just
+// written) to every registered index, persisting the updated sidecar
+// once every indexFlushInterval inserts rather than after each one.
+// c.m must already be held.
+func (c *C) indexRecordLocked(offset int64, data []byte) error {
+	for _, idx := range c.indexes {
+		key, err := idx.keyFunc(data)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			continue
+		}
+		idx.offsets[string(key)] = append(idx.offsets[string(key)], offset)
+		idx.dirty = true
+		idx.dirtyWrites++
+		if idx.dirtyWrites < indexFlushInterval {
+			continue
+		}
+		if err := c.saveIndexLocked(idx); err != nil {
+			return err
+		}
+		idx.dirty = false
+		idx.dirtyWrites = 0
+	}
+	return nil
+}
+
+// flushIndexesLocked persists every index whose offsets have changed
+// since its last save. c.m must already be held.
+func (c *C) flushIndexesLocked() error {
+	for _, idx := range c.indexes {
+		if !idx.dirty {
+			continue
+		}
+		if err := c.saveIndexLocked(idx); err != nil {
+			return err
+		}
+		idx.dirty = false
+		idx.dirtyWrites = 0
+	}
+	return nil
+}
+
+// buildIndexLocked populates idx.offsets from a full scan of the
+// collection's current data file. c.m must already be held.
+func (c *C) buildIndexLocked(idx *Index) error {
+	f, err := c.file()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+	var offset int64
+	s := c.scanner(f)
+	for s.Scan() {
+		data := s.Bytes()
+		key, err := idx.keyFunc(data)
+		if err != nil {
+			return err
+		}
+		if key != nil {
+			idx.offsets[string(key)] = append(idx.offsets[string(key)], offset)
+		}
+		offset += c.recordSize(data)
+	}
+	return s.Err()
+}
+
+// saveIndexLocked writes idx to its sidecar file, recording the data
+// file's current size/mtime in the header so staleness can later be
+// detected. c.m must already be held.
+func (c *C) saveIndexLocked(idx *Index) error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	data := indexFile{
+		Header: indexHeader{
+			DataSize:    info.Size(),
+			DataModTime: info.ModTime().UnixNano(),
+		},
+		Offsets: idx.offsets,
+	}
+	tempfile, err := ioutil.TempFile(filepath.Dir(c.path), "filedb-idx")
+	if err != nil {
+		return err
+	}
+	tempfilename := tempfile.Name()
+	if err := gob.NewEncoder(tempfile).Encode(&data); err != nil {
+		tempfile.Close()
+		os.Remove(tempfilename)
+		return err
+	}
+	tempfile.Close()
+	return os.Rename(tempfilename, c.indexPath(idx.name))
+}
+
+// loadIndexLocked reads the named index's sidecar file, returning
+// ok=false (with no error) if the sidecar doesn't exist, is stale
+// relative to the current data file, or the data file doesn't exist
+// yet. c.m must already be held.
+func (c *C) loadIndexLocked(name string) (*Index, bool, error) {
+	b, err := ioutil.ReadFile(c.indexPath(name))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var data indexFile
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&data); err != nil {
+		return nil, false, err
+	}
+	info, err := os.Stat(c.path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if data.Header.DataSize != info.Size() || data.Header.DataModTime != info.ModTime().UnixNano() {
+		return nil, false, nil
+	}
+	return &Index{name: name, offsets: data.Offsets}, true, nil
+}
+
+// recordSize returns the number of bytes writeRecord writes to store
+// data, including its framing (a trailing newline, or a length prefix).
+func (c *C) recordSize(data []byte) int64 {
+	if c.codecFor().Framed() {
+		return int64(4 + len(data))
+	}
+	return int64(len(data) + 1)
+}
+
+// readRecordAt reads the single record starting at offset in f,
+// accounting for the collection's framing.
+func (c *C) readRecordAt(f *os.File, offset int64) ([]byte, error) {
+	if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	if c.codecFor().Framed() {
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(f, lenBuf[:]); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\n"), nil
+}
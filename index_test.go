@@ -0,0 +1,125 @@
+package filedb_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/matryer/filedb"
+	"github.com/stretchr/testify/require"
+)
+
+func nameKeyFunc(raw []byte) ([]byte, error) {
+	var v struct{ Name string }
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return []byte(v.Name), nil
+}
+
+func TestEnsureIndexAndFindByIndex(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.C("TestIndexDB")
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Mat", "location": "San Francisco"}))
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Ryan", "location": "Costa Rica"}))
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Mat", "location": "Boulder"}))
+
+	require.NoError(t, c.EnsureIndex("name", nameKeyFunc))
+
+	var locations []string
+	err = c.FindByIndex("name", []byte("Mat"), func(i int, data []byte) bool {
+		var v struct{ Location string }
+		require.NoError(t, json.Unmarshal(data, &v))
+		locations = append(locations, v.Location)
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"San Francisco", "Boulder"}, locations)
+
+	// inserting after EnsureIndex should keep the index up to date
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Mat", "location": "Seattle"}))
+	locations = nil
+	err = c.FindByIndex("name", []byte("Mat"), func(i int, data []byte) bool {
+		var v struct{ Location string }
+		require.NoError(t, json.Unmarshal(data, &v))
+		locations = append(locations, v.Location)
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"San Francisco", "Boulder", "Seattle"}, locations)
+
+}
+
+func TestIndexPersistIsBatchedAndFlushedOnClose(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	c, err := db.C("TestIndexBatchDB")
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Jeff"}))
+	require.NoError(t, c.EnsureIndex("name", nameKeyFunc))
+
+	sidecar := fmt.Sprintf("%s.idx.name", c.Path())
+	infoAfterBuild, err := os.Stat(sidecar)
+	require.NoError(t, err)
+
+	// Fewer inserts than indexFlushInterval should update the in-memory
+	// index but not rewrite the sidecar on every single one.
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Mat"}))
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Ryan"}))
+
+	var found bool
+	require.NoError(t, c.FindByIndex("name", []byte("Mat"), func(i int, data []byte) bool {
+		found = true
+		return false
+	}))
+	require.True(t, found, "in-memory index should see inserts immediately")
+
+	infoAfterInserts, err := os.Stat(sidecar)
+	require.NoError(t, err)
+	require.Equal(t, infoAfterBuild.Size(), infoAfterInserts.Size(),
+		"sidecar should not be rewritten for every insert below indexFlushInterval")
+
+	// Closing the collection must flush any batched, unpersisted index
+	// writes so a later Dial doesn't need to fall back to a full rebuild.
+	db.Close()
+
+	infoAfterClose, err := os.Stat(sidecar)
+	require.NoError(t, err)
+	require.True(t, infoAfterClose.Size() > infoAfterInserts.Size(),
+		"closing the collection should flush pending index writes")
+
+}
+
+func TestRebuildIndexes(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.C("TestRebuildIndexDB")
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Jeff", "location": "Washington State"}))
+	require.NoError(t, c.EnsureIndex("name", nameKeyFunc))
+
+	require.NoError(t, c.RebuildIndexes())
+
+	var found bool
+	err = c.FindByIndex("name", []byte("Jeff"), func(i int, data []byte) bool {
+		found = true
+		return false
+	})
+	require.NoError(t, err)
+	require.True(t, found)
+
+}
@@ -0,0 +1,264 @@
+package filedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// MessagePackCodec stores values using the MessagePack binary format
+// (https://msgpack.org/). MessagePack has no reflection support of its
+// own, so Marshal and Unmarshal round-trip v through encoding/json's
+// generic representation (nil, bool, float64, string,
+// []interface{}, map[string]interface{}) to get struct-tag-aware
+// decoding for free, then encode or decode that generic tree directly
+// as MessagePack bytes on the wire. MessagePack output is binary and
+// may contain newline bytes, so records are length-prefixed rather
+// than newline-terminated.
+type MessagePackCodec struct{}
+
+// Marshal encodes v as MessagePack.
+func (MessagePackCodec) Marshal(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := msgpackEncode(&buf, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes MessagePack data into v.
+func (MessagePackCodec) Unmarshal(data []byte, v interface{}) error {
+	generic, _, err := msgpackDecode(data)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Ext returns the file extension used for MessagePack-encoded collections.
+func (MessagePackCodec) Ext() string { return ".msgpackdb" }
+
+// Framed returns true; MessagePack output is binary.
+func (MessagePackCodec) Framed() bool { return true }
+
+func msgpackEncode(buf *bytes.Buffer, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if val {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case float64:
+		buf.WriteByte(0xcb)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(val))
+		buf.Write(b[:])
+	case string:
+		msgpackEncodeStr(buf, val)
+	case []interface{}:
+		msgpackEncodeArrayHeader(buf, len(val))
+		for _, e := range val {
+			if err := msgpackEncode(buf, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		msgpackEncodeMapHeader(buf, len(val))
+		for k, mv := range val {
+			msgpackEncodeStr(buf, k)
+			if err := msgpackEncode(buf, mv); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("filedb: msgpack: unsupported value type %T", v)
+	}
+	return nil
+}
+
+func msgpackEncodeStr(buf *bytes.Buffer, s string) {
+	n := len(s)
+	switch {
+	case n <= 0x1f:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= 0xff:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(0xda)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(0xdb)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	}
+	buf.WriteString(s)
+}
+
+func msgpackEncodeArrayHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= 0x0f:
+		buf.WriteByte(0x90 | byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(0xdc)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(0xdd)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	}
+}
+
+func msgpackEncodeMapHeader(buf *bytes.Buffer, n int) {
+	switch {
+	case n <= 0x0f:
+		buf.WriteByte(0x80 | byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(0xde)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	default:
+		buf.WriteByte(0xdf)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	}
+}
+
+// msgpackDecode decodes a single MessagePack value from the front of
+// data, returning the decoded value and the number of bytes consumed.
+func msgpackDecode(data []byte) (interface{}, int, error) {
+	if len(data) == 0 {
+		return nil, 0, fmt.Errorf("filedb: msgpack: unexpected end of data")
+	}
+	b := data[0]
+	switch {
+	case b == 0xc0:
+		return nil, 1, nil
+	case b == 0xc2:
+		return false, 1, nil
+	case b == 0xc3:
+		return true, 1, nil
+	case b == 0xcb:
+		if len(data) < 9 {
+			return nil, 0, fmt.Errorf("filedb: msgpack: truncated float64")
+		}
+		bits := binary.BigEndian.Uint64(data[1:9])
+		return math.Float64frombits(bits), 9, nil
+	case b&0xe0 == 0xa0:
+		n := int(b & 0x1f)
+		return msgpackDecodeStr(data[1:], n, 1)
+	case b == 0xd9:
+		if len(data) < 2 {
+			return nil, 0, fmt.Errorf("filedb: msgpack: truncated str8 length")
+		}
+		return msgpackDecodeStr(data[2:], int(data[1]), 2)
+	case b == 0xda:
+		if len(data) < 3 {
+			return nil, 0, fmt.Errorf("filedb: msgpack: truncated str16 length")
+		}
+		return msgpackDecodeStr(data[3:], int(binary.BigEndian.Uint16(data[1:3])), 3)
+	case b == 0xdb:
+		if len(data) < 5 {
+			return nil, 0, fmt.Errorf("filedb: msgpack: truncated str32 length")
+		}
+		return msgpackDecodeStr(data[5:], int(binary.BigEndian.Uint32(data[1:5])), 5)
+	case b&0xf0 == 0x90:
+		return msgpackDecodeArray(data[1:], int(b&0x0f), 1)
+	case b == 0xdc:
+		if len(data) < 3 {
+			return nil, 0, fmt.Errorf("filedb: msgpack: truncated array16 length")
+		}
+		return msgpackDecodeArray(data[3:], int(binary.BigEndian.Uint16(data[1:3])), 3)
+	case b == 0xdd:
+		if len(data) < 5 {
+			return nil, 0, fmt.Errorf("filedb: msgpack: truncated array32 length")
+		}
+		return msgpackDecodeArray(data[5:], int(binary.BigEndian.Uint32(data[1:5])), 5)
+	case b&0xf0 == 0x80:
+		return msgpackDecodeMap(data[1:], int(b&0x0f), 1)
+	case b == 0xde:
+		if len(data) < 3 {
+			return nil, 0, fmt.Errorf("filedb: msgpack: truncated map16 length")
+		}
+		return msgpackDecodeMap(data[3:], int(binary.BigEndian.Uint16(data[1:3])), 3)
+	case b == 0xdf:
+		if len(data) < 5 {
+			return nil, 0, fmt.Errorf("filedb: msgpack: truncated map32 length")
+		}
+		return msgpackDecodeMap(data[5:], int(binary.BigEndian.Uint32(data[1:5])), 5)
+	default:
+		return nil, 0, fmt.Errorf("filedb: msgpack: unsupported type byte 0x%02x", b)
+	}
+}
+
+func msgpackDecodeStr(data []byte, n, headerLen int) (interface{}, int, error) {
+	if len(data) < n {
+		return nil, 0, fmt.Errorf("filedb: msgpack: truncated string")
+	}
+	return string(data[:n]), headerLen + n, nil
+}
+
+func msgpackDecodeArray(data []byte, n, headerLen int) (interface{}, int, error) {
+	arr := make([]interface{}, n)
+	consumed := headerLen
+	for i := 0; i < n; i++ {
+		v, adv, err := msgpackDecode(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		arr[i] = v
+		data = data[adv:]
+		consumed += adv
+	}
+	return arr, consumed, nil
+}
+
+func msgpackDecodeMap(data []byte, n, headerLen int) (interface{}, int, error) {
+	m := make(map[string]interface{}, n)
+	consumed := headerLen
+	for i := 0; i < n; i++ {
+		kv, kadv, err := msgpackDecode(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		key, ok := kv.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("filedb: msgpack: map key must be a string, got %T", kv)
+		}
+		data = data[kadv:]
+		consumed += kadv
+		v, vadv, err := msgpackDecode(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		m[key] = v
+		data = data[vadv:]
+		consumed += vadv
+	}
+	return m, consumed, nil
+}
@@ -0,0 +1,190 @@
+package filedb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/matryer/filedb/query"
+)
+
+// Find decodes every JSON record matching q into results, which must be
+// a pointer to a slice. q.Fields is read once before the scan begins;
+// each record is then evaluated by extractFields, which walks the
+// record's tokens and decodes only those fields, skipping the bytes of
+// every field q doesn't reference instead of unmarshaling the whole
+// record. Only records that match pay for a second, full
+// encoding/json.Unmarshal, into the slice's element type.
+//
+// Projection (selecting a subset of a record's fields) isn't a
+// separate feature: results' element type already acts as the
+// projection, since json.Unmarshal only populates the fields present
+// on it and ignores the rest.
+//     type NameOnly struct{ Name string `json:"name"` }
+//     var people []NameOnly
+//     err := c.Find(query.Eq("name", "Mat"), &people) // only Name is populated
+func (c *C) Find(q query.Query, results interface{}) error {
+	resultsVal := reflect.ValueOf(results)
+	if resultsVal.Kind() != reflect.Ptr || resultsVal.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("filedb: results argument must be a pointer to a slice")
+	}
+	sliceVal := resultsVal.Elem()
+	elemType := sliceVal.Type().Elem()
+	fields := fieldSet(q)
+
+	var ferr error
+	err := c.ForEach(func(i int, data []byte) bool {
+		vals, err := extractFields(data, fields)
+		if err != nil {
+			ferr = err
+			return true
+		}
+		if !q.Match(vals) {
+			return false
+		}
+		elemPtr := reflect.New(elemType)
+		if err := json.Unmarshal(data, elemPtr.Interface()); err != nil {
+			ferr = err
+			return true
+		}
+		sliceVal.Set(reflect.Append(sliceVal, elemPtr.Elem()))
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	return ferr
+}
+
+// Remove deletes every record matching q. Like Find, matching is done
+// by extractFields against q.Fields, so non-matching records never pay
+// for a full decode.
+func (c *C) Remove(q query.Query) error {
+	fields := fieldSet(q)
+	return c.RemoveEach(func(i int, data []byte) (bool, bool) {
+		vals, err := extractFields(data, fields)
+		if err != nil {
+			return false, false
+		}
+		return q.Match(vals), false
+	})
+}
+
+// Update merges the fields of patch into every record matching q. patch
+// is marshaled to JSON and its top-level fields are merged into each
+// matching document, leaving unmatched records untouched. Matching is
+// done by extractFields against q.Fields; only records that match are
+// then fully decoded, merged with patch, and re-encoded.
+func (c *C) Update(q query.Query, patch interface{}) error {
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	var patchDoc map[string]interface{}
+	if err := json.Unmarshal(b, &patchDoc); err != nil {
+		return err
+	}
+	fields := fieldSet(q)
+	return c.SelectEach(func(i int, data []byte) (bool, []byte, bool) {
+		vals, err := extractFields(data, fields)
+		if err != nil {
+			return true, data, false
+		}
+		if !q.Match(vals) {
+			return true, data, false
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return true, data, false
+		}
+		for k, v := range patchDoc {
+			doc[k] = v
+		}
+		merged, err := json.Marshal(doc)
+		if err != nil {
+			return true, data, false
+		}
+		return true, merged, false
+	})
+}
+
+// fieldSet builds the set of top-level field names q.Fields references,
+// for passing to extractFields on every record.
+func fieldSet(q query.Query) map[string]struct{} {
+	fields := q.Fields()
+	set := make(map[string]struct{}, len(fields))
+	for _, f := range fields {
+		set[f] = struct{}{}
+	}
+	return set
+}
+
+// extractFields streams through a JSON object record token by token,
+// decoding only the top-level fields named in want and skipping every
+// other field's value without decoding it. It returns as soon as every
+// wanted field has been seen, so a match on an early field in a large
+// record never pays to parse the rest of it.
+func extractFields(data []byte, want map[string]struct{}) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("filedb: record is not a JSON object")
+	}
+
+	vals := make(map[string]interface{}, len(want))
+	remaining := len(want)
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := keyTok.(string)
+		if _, ok := want[key]; !ok {
+			if err := skipValue(dec); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		vals[key] = v
+		if remaining--; remaining == 0 {
+			return vals, nil
+		}
+	}
+	return vals, nil
+}
+
+// skipValue consumes the next JSON value from dec without decoding it
+// into a Go value, by walking its tokens and tracking delimiter depth.
+func skipValue(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok || (delim != '{' && delim != '[') {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if d, ok := tok.(json.Delim); ok {
+			switch d {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Package query provides a small declarative query DSL for selecting
+// JSON documents stored in a filedb collection, for use with
+// (*filedb.C).Find, Remove and Update.
+//
+// A Query declares the top-level fields it needs via Fields and is
+// evaluated against only those fields via Match. The caller (filedb)
+// pulls Fields out of a record with a streaming, Token-based scan
+// rather than a full encoding/json.Unmarshal of the record, so
+// evaluating a Query against a non-matching record never pays for
+// decoding the fields it doesn't reference.
+package query
+
+import (
+	"reflect"
+	"regexp"
+)
+
+// Query is a compiled predicate over a sparse set of a JSON document's
+// top-level fields.
+type Query interface {
+	// Fields lists the top-level field names this query reads. The
+	// caller extracts only these fields from a record before calling
+	// Match.
+	Fields() []string
+	// Match reports whether vals, a map containing the fields named by
+	// Fields that were present in the record, satisfies the query.
+	Match(vals map[string]interface{}) bool
+}
+
+type eqQuery struct {
+	field string
+	value interface{}
+}
+
+// Eq matches documents where field equals value.
+func Eq(field string, value interface{}) Query {
+	return eqQuery{field: field, value: value}
+}
+
+func (q eqQuery) Fields() []string { return []string{q.field} }
+
+func (q eqQuery) Match(vals map[string]interface{}) bool {
+	v, ok := vals[q.field]
+	if !ok {
+		return false
+	}
+	if n1, n2, ok := asFloats(v, q.value); ok {
+		return n1 == n2
+	}
+	return reflect.DeepEqual(v, q.value)
+}
+
+type gtQuery struct {
+	field string
+	value float64
+}
+
+// Gt matches documents where field is a number greater than value.
+func Gt(field string, value float64) Query {
+	return gtQuery{field: field, value: value}
+}
+
+func (q gtQuery) Fields() []string { return []string{q.field} }
+
+func (q gtQuery) Match(vals map[string]interface{}) bool {
+	v, ok := vals[q.field]
+	if !ok {
+		return false
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return false
+	}
+	return n > q.value
+}
+
+type regexQuery struct {
+	field string
+	re    *regexp.Regexp
+}
+
+// Regex matches documents where field, treated as a string, matches
+// pattern.
+func Regex(field, pattern string) Query {
+	return regexQuery{field: field, re: regexp.MustCompile(pattern)}
+}
+
+func (q regexQuery) Fields() []string { return []string{q.field} }
+
+func (q regexQuery) Match(vals map[string]interface{}) bool {
+	v, ok := vals[q.field]
+	if !ok {
+		return false
+	}
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	return q.re.MatchString(s)
+}
+
+type andQuery struct {
+	queries []Query
+}
+
+// And matches documents that match every one of queries.
+func And(queries ...Query) Query {
+	return andQuery{queries: queries}
+}
+
+func (q andQuery) Fields() []string {
+	var fields []string
+	for _, sub := range q.queries {
+		fields = append(fields, sub.Fields()...)
+	}
+	return fields
+}
+
+func (q andQuery) Match(vals map[string]interface{}) bool {
+	for _, sub := range q.queries {
+		if !sub.Match(vals) {
+			return false
+		}
+	}
+	return true
+}
+
+// asFloats reports whether both a and b can be interpreted as numbers,
+// returning their float64 values if so. a is expected to come from a
+// decoded JSON document (always float64); b is a query argument that
+// may be any Go numeric type.
+func asFloats(a, b interface{}) (float64, float64, bool) {
+	af, ok := a.(float64)
+	if !ok {
+		return 0, 0, false
+	}
+	switch bv := b.(type) {
+	case float64:
+		return af, bv, true
+	case float32:
+		return af, float64(bv), true
+	case int:
+		return af, float64(bv), true
+	case int64:
+		return af, float64(bv), true
+	}
+	return 0, 0, false
+}
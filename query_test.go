@@ -0,0 +1,92 @@
+package filedb_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/matryer/filedb"
+	"github.com/matryer/filedb/query"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFind(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.C("TestFindDB")
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Mat", "age": 30, "location": "San Francisco"}))
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Ryan", "age": 25, "location": "Boulder"}))
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Tyler", "age": 35, "location": "Salt Lake City"}))
+
+	type person struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+
+	var results []person
+	require.NoError(t, c.Find(query.Gt("age", 28), &results))
+	require.Equal(t, 2, len(results))
+	require.Equal(t, "Mat", results[0].Name)
+	require.Equal(t, "Tyler", results[1].Name)
+
+	results = nil
+	require.NoError(t, c.Find(query.And(query.Eq("name", "Mat"), query.Regex("location", "^San")), &results))
+	require.Equal(t, 1, len(results))
+	require.Equal(t, "Mat", results[0].Name)
+
+}
+
+func TestRemoveWithQuery(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.C("TestRemoveWithQueryDB")
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Mat", "age": 30}))
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Ryan", "age": 25}))
+
+	require.NoError(t, c.Remove(query.Eq("name", "Ryan")))
+
+	var names []string
+	err = c.ForEach(func(i int, data []byte) bool {
+		var doc map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &doc))
+		names = append(names, doc["name"].(string))
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Mat"}, names)
+
+}
+
+func TestUpdateWithQuery(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.C("TestUpdateWithQueryDB")
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Mat", "location": "San Francisco"}))
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Ryan", "location": "Boulder"}))
+
+	require.NoError(t, c.Update(query.Eq("name", "Mat"), map[string]interface{}{"location": "Seattle"}))
+
+	type person struct {
+		Name     string `json:"name"`
+		Location string `json:"location"`
+	}
+	var results []person
+	require.NoError(t, c.Find(query.Eq("name", "Mat"), &results))
+	require.Equal(t, 1, len(results))
+	require.Equal(t, "Seattle", results[0].Location)
+
+}
@@ -0,0 +1,403 @@
+package filedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Option configures a DB returned by Dial.
+type Option func(*DB)
+
+// WithMaxSegmentBytes enables segment rotation: once a collection's
+// active segment file grows past n bytes, further inserts go to a new
+// numbered segment ("TestDB.filedb.0", "TestDB.filedb.1", ...) instead
+// of growing a single file without bound. The default, 0, disables
+// rotation, so existing callers keep filedb's original single-file
+// behaviour.
+func WithMaxSegmentBytes(n int64) Option {
+	return func(db *DB) { db.maxSegmentBytes = n }
+}
+
+// WithAutoCompact enables automatic compaction on segmented
+// collections: once more than n non-active segments have accumulated,
+// the Insert that triggers the next rotation also runs Compact.
+func WithAutoCompact(n int) Option {
+	return func(db *DB) { db.autoCompactSegments = n }
+}
+
+// segmented reports whether this collection rotates into numbered
+// segment files rather than growing a single data file.
+func (c *C) segmented() bool {
+	return c.db.maxSegmentBytes > 0
+}
+
+func (c *C) segmentPath(n int) string {
+	return fmt.Sprintf("%s.%d", c.path, n)
+}
+
+// discoverSegments lists the segment numbers already on disk for this
+// collection, in order.
+func (c *C) discoverSegments() ([]int, error) {
+	files, err := ioutil.ReadDir(filepath.Dir(c.path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(c.path) + "."
+	var nums []int
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		n, err := strconv.Atoi(name[len(prefix):])
+		if err != nil {
+			continue
+		}
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	return nums, nil
+}
+
+// activeSegment returns the file handle for the current tail segment,
+// creating segment 0 if none exist yet. c.m must already be held.
+func (c *C) activeSegment() (*os.File, error) {
+	if c.f != nil {
+		return c.f, nil
+	}
+	nums, err := c.discoverSegments()
+	if err != nil {
+		return nil, err
+	}
+	if len(nums) == 0 {
+		nums = []int{0}
+	}
+	c.activeSegNum = nums[len(nums)-1]
+	f, err := os.OpenFile(c.segmentPath(c.activeSegNum), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, err
+	}
+	c.f = f
+	return f, nil
+}
+
+// rotateIfNeeded starts a new tail segment once the active one has
+// grown past MaxSegmentBytes, and runs Compact if auto-compaction is
+// enabled and enough segments have piled up. c.m must already be held.
+func (c *C) rotateIfNeeded() error {
+	info, err := c.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < c.db.maxSegmentBytes {
+		return nil
+	}
+	c.f.Close()
+	c.activeSegNum++
+	f, err := os.OpenFile(c.segmentPath(c.activeSegNum), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0660)
+	if err != nil {
+		return err
+	}
+	c.f = f
+	if c.db.autoCompactSegments > 0 {
+		nums, err := c.discoverSegments()
+		if err != nil {
+			return err
+		}
+		if len(nums) > c.db.autoCompactSegments {
+			return c.compactLocked()
+		}
+	}
+	return nil
+}
+
+// Every record in a segmented collection is stored as a one-byte
+// tombstone flag followed by its normal (newline- or length-framed)
+// encoding. RemoveEach flips the flag to segTombstoneDead in place,
+// without rewriting the record or the file it lives in; Compact is
+// what actually reclaims the space by dropping dead records on a
+// merge.
+const (
+	segTombstoneLive = byte(1)
+	segTombstoneDead = byte(0)
+)
+
+// writeSegmentRecord appends data to f as a live record, prefixed with
+// its tombstone flag.
+func (c *C) writeSegmentRecord(f *os.File, data []byte) error {
+	if _, err := f.Write([]byte{segTombstoneLive}); err != nil {
+		return err
+	}
+	return c.writeRecord(f, data)
+}
+
+// segRecord is one decoded record from a segment file, as produced by
+// readSegmentRecords.
+type segRecord struct {
+	offset int64 // position of the tombstone flag byte within the segment
+	live   bool
+	data   []byte
+}
+
+// readSegmentRecords reads every record (live and dead) out of a
+// segment file. Segments are bounded by MaxSegmentBytes, so reading one
+// in full is bounded too.
+func (c *C) readSegmentRecords(f *os.File) ([]segRecord, error) {
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	framed := c.codecFor().Framed()
+	var records []segRecord
+	var pos int64
+	n := int64(len(b))
+	for pos < n {
+		offset := pos
+		if pos+1 > n {
+			break
+		}
+		live := b[pos] == segTombstoneLive
+		pos++
+		var data []byte
+		if framed {
+			if pos+4 > n {
+				break
+			}
+			size := int64(binary.BigEndian.Uint32(b[pos : pos+4]))
+			pos += 4
+			if pos+size > n {
+				break
+			}
+			data = b[pos : pos+size]
+			pos += size
+		} else {
+			idx := bytes.IndexByte(b[pos:], '\n')
+			if idx < 0 {
+				break
+			}
+			data = b[pos : pos+int64(idx)]
+			pos += int64(idx) + 1
+		}
+		records = append(records, segRecord{offset: offset, live: live, data: data})
+	}
+	return records, nil
+}
+
+// insertSegmentedLocked is Insert's implementation for collections
+// with segment rotation enabled. c.m must already be held.
+func (c *C) insertSegmentedLocked(o []byte) error {
+	f, err := c.activeSegment()
+	if err != nil {
+		return err
+	}
+	offset, err := f.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+	if err := c.writeSegmentRecord(f, o); err != nil {
+		return err
+	}
+	if err := c.indexRecordLocked(offset, o); err != nil {
+		return err
+	}
+	return c.rotateIfNeeded()
+}
+
+// forEachSegmentedLocked is ForEach's implementation for collections
+// with segment rotation enabled: it iterates every segment in order,
+// skipping tombstoned records and numbering the rest continuously
+// across segment boundaries. c.m must already be held.
+func (c *C) forEachSegmentedLocked(fn func(int, []byte) bool) error {
+	nums, err := c.discoverSegments()
+	if err != nil {
+		return err
+	}
+	i := 0
+	for _, n := range nums {
+		stop, err := c.forEachInSegment(n, &i, fn)
+		if err != nil {
+			return err
+		}
+		if stop {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *C) forEachInSegment(n int, i *int, fn func(int, []byte) bool) (stop bool, err error) {
+	f, err := os.Open(c.segmentPath(n))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	records, err := c.readSegmentRecords(f)
+	if err != nil {
+		return false, err
+	}
+	for _, rec := range records {
+		if !rec.live {
+			continue
+		}
+		if fn(*i, rec.data) {
+			return true, nil
+		}
+		*i = *i + 1
+	}
+	return false, nil
+}
+
+// removeEachSegmentedLocked is RemoveEach's implementation for
+// collections with segment rotation enabled. Unlike the unsegmented
+// path (which goes through SelectEach and rewrites the whole file), a
+// matched record is deleted by flipping its tombstone flag in place
+// with a single-byte WriteAt — no segment is rewritten. c.m must
+// already be held.
+func (c *C) removeEachSegmentedLocked(fn func(int, []byte) (remove bool, stop bool)) error {
+	nums, err := c.discoverSegments()
+	if err != nil {
+		return err
+	}
+	i := 0
+	for _, n := range nums {
+		stop, err := c.removeEachInSegment(n, &i, fn)
+		if err != nil {
+			return err
+		}
+		if stop {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *C) removeEachInSegment(n int, i *int, fn func(int, []byte) (bool, bool)) (stop bool, err error) {
+	f, err := os.OpenFile(c.segmentPath(n), os.O_RDWR, 0660)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	records, err := c.readSegmentRecords(f)
+	if err != nil {
+		return false, err
+	}
+	for _, rec := range records {
+		if !rec.live {
+			continue
+		}
+		remove, doStop := fn(*i, rec.data)
+		if remove {
+			if _, err := f.WriteAt([]byte{segTombstoneDead}, rec.offset); err != nil {
+				return false, err
+			}
+		}
+		*i = *i + 1
+		if doStop {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// selectEachSegmentedLocked is SelectEach's implementation for
+// collections with segment rotation enabled. It walks every live
+// record across every segment in order, writing kept records into a
+// single fresh segment 0 and dropping tombstoned and excluded records
+// alike; rotation resumes from there on the next Insert. c.m must
+// already be held.
+func (c *C) selectEachSegmentedLocked(fn func(int, []byte) (bool, []byte, bool)) error {
+	nums, err := c.discoverSegments()
+	if err != nil {
+		return err
+	}
+	tempfile, err := ioutil.TempFile(filepath.Dir(c.path), "filedb")
+	if err != nil {
+		return err
+	}
+	tempfilename := tempfile.Name()
+	defer func() {
+		tempfile.Close()
+		os.Remove(tempfilename)
+	}()
+
+	i := 0
+	stop := false
+	for _, n := range nums {
+		if stop {
+			break
+		}
+		f, err := os.Open(c.segmentPath(n))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		records, err := c.readSegmentRecords(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if !rec.live {
+				continue
+			}
+			include, data, doStop := fn(i, rec.data)
+			if include {
+				if err := c.writeSegmentRecord(tempfile, data); err != nil {
+					return err
+				}
+			}
+			i++
+			if doStop {
+				stop = true
+				break
+			}
+		}
+	}
+
+	if c.f != nil {
+		c.f.Close()
+		c.f = nil
+	}
+	for _, n := range nums {
+		os.Remove(c.segmentPath(n))
+	}
+	if err := os.Rename(tempfilename, c.segmentPath(0)); err != nil {
+		return err
+	}
+	c.activeSegNum = 0
+	return nil
+}
+
+// Compact merges every segment of a segmented collection into a single
+// fresh segment, dropping tombstoned records and reclaiming their
+// space. Collections without segment rotation enabled (the default)
+// have nothing to compact and Compact is a no-op for them.
+func (c *C) Compact() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.compactLocked()
+}
+
+func (c *C) compactLocked() error {
+	if !c.segmented() {
+		return nil
+	}
+	return c.selectEachSegmentedLocked(func(i int, data []byte) (bool, []byte, bool) {
+		return true, data, false
+	})
+}
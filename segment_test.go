@@ -0,0 +1,83 @@
+package filedb_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/matryer/filedb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentRotation(t *testing.T) {
+
+	db, err := filedb.Dial("test/db", filedb.WithMaxSegmentBytes(20))
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.C("TestSegmentRotationDB")
+	require.NoError(t, err)
+	c.Drop()
+	for n := 0; n < 10; n++ {
+		os.Remove(fmt.Sprintf("%s.%d", c.Path(), n))
+	}
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, c.InsertJSON(map[string]interface{}{"n": i}))
+	}
+
+	_, err = os.Stat(c.Path() + ".1")
+	require.NoError(t, err, "inserting enough records should have rotated into a second segment")
+
+	var seen []int
+	err = c.ForEach(func(i int, data []byte) bool {
+		seen = append(seen, i)
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 5, len(seen), "ForEach should see records across all segments")
+
+	sizeBefore, err := os.Stat(c.Path() + ".0")
+	require.NoError(t, err)
+
+	var totalBeforeRemove int64
+	for n := 0; n < 2; n++ {
+		if info, err := os.Stat(fmt.Sprintf("%s.%d", c.Path(), n)); err == nil {
+			totalBeforeRemove += info.Size()
+		}
+	}
+
+	require.NoError(t, c.RemoveEach(func(i int, data []byte) (bool, bool) {
+		return i == 0, false
+	}))
+
+	sizeAfter, err := os.Stat(c.Path() + ".0")
+	require.NoError(t, err)
+	require.Equal(t, sizeBefore.Size(), sizeAfter.Size(), "RemoveEach should tombstone in place, not rewrite the segment")
+
+	seen = nil
+	err = c.ForEach(func(i int, data []byte) bool {
+		seen = append(seen, i)
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(seen))
+
+	require.NoError(t, c.Compact())
+
+	var totalAfterCompact int64
+	for n := 0; n < 2; n++ {
+		if info, err := os.Stat(fmt.Sprintf("%s.%d", c.Path(), n)); err == nil {
+			totalAfterCompact += info.Size()
+		}
+	}
+	require.True(t, totalAfterCompact < totalBeforeRemove,
+		"Compact should reclaim the tombstoned record's space")
+
+	seen = nil
+	err = c.ForEach(func(i int, data []byte) bool {
+		seen = append(seen, i)
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(seen), "Compact must not resurrect tombstoned records")
+}
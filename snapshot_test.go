@@ -0,0 +1,126 @@
+package filedb_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/matryer/filedb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBSnapshot(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.C("TestDBSnapshotDB")
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Mat"}))
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Ryan"}))
+
+	require.NoError(t, os.RemoveAll("test/backup"))
+	require.NoError(t, db.Snapshot("test/backup"))
+
+	backup, err := filedb.Dial("test/backup")
+	require.NoError(t, err)
+	defer backup.Close()
+	bc, err := backup.C("TestDBSnapshotDB")
+	require.NoError(t, err)
+
+	var names []string
+	err = bc.ForEach(func(i int, data []byte) bool {
+		names = append(names, string(data))
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(names))
+
+}
+
+func TestDBSnapshotIncludesSegmentedAndCodecCollections(t *testing.T) {
+
+	db, err := filedb.Dial("test/db", filedb.WithMaxSegmentBytes(20))
+	require.NoError(t, err)
+	defer db.Close()
+
+	seg, err := db.C("TestDBSnapshotSegmentedDB")
+	require.NoError(t, err)
+	seg.Drop()
+	for n := 0; n < 5; n++ {
+		os.Remove(seg.Path() + "." + string(rune('0'+n)))
+	}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, seg.InsertJSON(map[string]interface{}{"n": i}))
+	}
+	_, err = os.Stat(seg.Path() + ".1")
+	require.NoError(t, err, "test setup should have produced a second segment")
+
+	codecCol, err := db.CWithCodec("TestDBSnapshotCodecDB", filedb.GobCodec{})
+	require.NoError(t, err)
+	require.NoError(t, codecCol.Drop())
+	require.NoError(t, codecCol.InsertValue(codecPerson{Name: "Mat"}))
+
+	require.NoError(t, os.RemoveAll("test/backup-mixed"))
+	require.NoError(t, db.Snapshot("test/backup-mixed"))
+
+	backup, err := filedb.Dial("test/backup-mixed", filedb.WithMaxSegmentBytes(20))
+	require.NoError(t, err)
+	defer backup.Close()
+
+	backupSeg, err := backup.C("TestDBSnapshotSegmentedDB")
+	require.NoError(t, err)
+	var segSeen []int
+	require.NoError(t, backupSeg.ForEach(func(i int, data []byte) bool {
+		segSeen = append(segSeen, i)
+		return false
+	}))
+	require.Equal(t, 5, len(segSeen), "backup should contain every record from the segmented collection")
+
+	backupCodec, err := backup.CWithCodec("TestDBSnapshotCodecDB", filedb.GobCodec{})
+	require.NoError(t, err)
+	var people []codecPerson
+	require.NoError(t, backupCodec.ForEachValue(func(i int, decode func(v interface{}) error) bool {
+		var p codecPerson
+		require.NoError(t, decode(&p))
+		people = append(people, p)
+		return false
+	}))
+	require.Equal(t, 1, len(people))
+	require.Equal(t, "Mat", people[0].Name)
+
+}
+
+func TestCSnapshotAndRestore(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.C("TestCSnapshotDB")
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Tyler"}))
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Snapshot(&buf))
+
+	require.NoError(t, os.RemoveAll("test/restore"))
+	require.NoError(t, os.MkdirAll("test/restore", 0777))
+	restoreDB, err := filedb.Dial("test/restore")
+	require.NoError(t, err)
+	defer restoreDB.Close()
+
+	require.NoError(t, restoreDB.Restore(&buf))
+
+	rc, err := restoreDB.C("TestCSnapshotDB")
+	require.NoError(t, err)
+	var names []string
+	err = rc.ForEach(func(i int, data []byte) bool {
+		names = append(names, string(data))
+		return false
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(names))
+
+}
@@ -0,0 +1,324 @@
+package filedb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// txIntentSuffix marks the append-only intent log Commit writes while
+// staging a transaction, so Dial can find and recover it after a crash.
+const txIntentSuffix = ".filedb-tx-intent"
+
+type txOpKind int
+
+const (
+	txInsert txOpKind = iota
+	txSelectEach
+	txRemoveEach
+)
+
+type txOp struct {
+	kind     txOpKind
+	insert   []byte
+	selectFn func(int, []byte) (include bool, data []byte, stop bool)
+	removeFn func(int, []byte) (remove bool, stop bool)
+}
+
+// Tx is a batch of operations across one or more collections that
+// commits atomically: either every staged Insert, SelectEach and
+// RemoveEach takes effect, or (if Commit is never reached, or the
+// process crashes partway through it) none of them do.
+type Tx struct {
+	db   *DB
+	cols []*C
+	ops  map[*C][]txOp
+	done bool
+}
+
+// Begin starts a new transaction against db.
+func (db *DB) Begin() (*Tx, error) {
+	return &Tx{db: db, ops: make(map[*C][]txOp)}, nil
+}
+
+func (tx *Tx) stage(c *C, op txOp) {
+	if _, ok := tx.ops[c]; !ok {
+		tx.cols = append(tx.cols, c)
+	}
+	tx.ops[c] = append(tx.ops[c], op)
+}
+
+// Insert stages the addition of o to c, to take effect on Commit.
+func (tx *Tx) Insert(c *C, o []byte) error {
+	tx.stage(c, txOp{kind: txInsert, insert: o})
+	return nil
+}
+
+// SelectEach stages a rewrite of c, to take effect on Commit. fn
+// behaves exactly as it does for (*C).SelectEach, evaluated against the
+// collection's records as left by any operations staged earlier in
+// this transaction.
+func (tx *Tx) SelectEach(c *C, fn func(i int, data []byte) (include bool, newData []byte, stop bool)) error {
+	tx.stage(c, txOp{kind: txSelectEach, selectFn: fn})
+	return nil
+}
+
+// RemoveEach stages a removal from c, to take effect on Commit. fn
+// behaves exactly as it does for (*C).RemoveEach.
+func (tx *Tx) RemoveEach(c *C, fn func(i int, data []byte) (remove bool, stop bool)) error {
+	tx.stage(c, txOp{kind: txRemoveEach, removeFn: fn})
+	return nil
+}
+
+// Rollback discards every staged operation without touching any
+// collection's data file. It is always safe to call, including after
+// Commit.
+func (tx *Tx) Rollback() {
+	tx.ops = make(map[*C][]txOp)
+	tx.cols = nil
+	tx.done = true
+}
+
+// Commit applies every staged operation atomically. Each affected
+// collection's mutation is first staged into its own temp file
+// (mirroring the temp-file rewrite SelectEach already does); an intent
+// log recording where each temp file belongs is fsynced and marked
+// committed before any temp file is renamed into place, so a crash
+// midway through Commit leaves Dial able to finish or undo it cleanly.
+//
+// Commit rejects collections with segment rotation enabled or with any
+// EnsureIndex registered: rewriting a collection's whole file out from
+// under its indexes would leave them pointing at the wrong byte
+// offsets with nothing to detect it, and neither subsystem knows how
+// to stage alongside the other yet.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return fmt.Errorf("filedb: transaction already committed or rolled back")
+	}
+	tx.done = true
+
+	cols := append([]*C{}, tx.cols...)
+	sort.Slice(cols, func(i, j int) bool { return cols[i].path < cols[j].path })
+	for _, c := range cols {
+		c.m.Lock()
+		defer c.m.Unlock()
+		if c.segmented() {
+			return fmt.Errorf("filedb: transactions are not supported on collections with segment rotation enabled")
+		}
+		if len(c.indexes) > 0 {
+			return fmt.Errorf("filedb: transactions are not supported on collections with indexes (EnsureIndex) enabled")
+		}
+	}
+
+	type staged struct {
+		c        *C
+		tempPath string
+	}
+	var stagedFiles []staged
+	cleanup := func() {
+		for _, s := range stagedFiles {
+			os.Remove(s.tempPath)
+		}
+	}
+
+	for _, c := range cols {
+		records, err := readAllRecordsLocked(c)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		for _, op := range tx.ops[c] {
+			records = applyTxOp(records, op)
+		}
+
+		tempfile, err := ioutil.TempFile(filepath.Dir(c.path), "filedb-tx")
+		if err != nil {
+			cleanup()
+			return err
+		}
+		for _, rec := range records {
+			if err := c.writeRecord(tempfile, rec); err != nil {
+				tempfile.Close()
+				os.Remove(tempfile.Name())
+				cleanup()
+				return err
+			}
+		}
+		if err := tempfile.Sync(); err != nil {
+			tempfile.Close()
+			os.Remove(tempfile.Name())
+			cleanup()
+			return err
+		}
+		tempfile.Close()
+		stagedFiles = append(stagedFiles, staged{c: c, tempPath: tempfile.Name()})
+	}
+
+	logFile, err := ioutil.TempFile(tx.db.path, "filedb-tx-log")
+	if err != nil {
+		cleanup()
+		return err
+	}
+	var logBuf bytes.Buffer
+	for _, s := range stagedFiles {
+		fmt.Fprintf(&logBuf, "%s\t%s\n", s.tempPath, s.c.path)
+	}
+	if _, err := logFile.Write(logBuf.Bytes()); err != nil {
+		logFile.Close()
+		os.Remove(logFile.Name())
+		cleanup()
+		return err
+	}
+	if err := logFile.Sync(); err != nil {
+		logFile.Close()
+		os.Remove(logFile.Name())
+		cleanup()
+		return err
+	}
+	logFile.Close()
+	logPath := logFile.Name() + txIntentSuffix
+	if err := os.Rename(logFile.Name(), logPath); err != nil {
+		cleanup()
+		return err
+	}
+
+	// Commit marker: once this is durably appended, recovery finishes
+	// the renames below rather than discarding the temp files.
+	marker, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0660)
+	if err != nil {
+		return err
+	}
+	if _, err := marker.WriteString("COMMIT\n"); err != nil {
+		marker.Close()
+		return err
+	}
+	if err := marker.Sync(); err != nil {
+		marker.Close()
+		return err
+	}
+	marker.Close()
+
+	for _, s := range stagedFiles {
+		s.c.close()
+		if err := os.Rename(s.tempPath, s.c.path); err != nil {
+			return err
+		}
+	}
+	return os.Remove(logPath)
+}
+
+// readAllRecordsLocked reads every record currently in c's data file.
+// c.m must already be held.
+func readAllRecordsLocked(c *C) ([][]byte, error) {
+	f, err := c.file()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+	var records [][]byte
+	s := c.scanner(f)
+	for s.Scan() {
+		b := s.Bytes()
+		cp := make([]byte, len(b))
+		copy(cp, b)
+		records = append(records, cp)
+	}
+	return records, s.Err()
+}
+
+// applyTxOp returns the result of applying a single staged operation to
+// records.
+func applyTxOp(records [][]byte, op txOp) [][]byte {
+	switch op.kind {
+	case txInsert:
+		return append(records, op.insert)
+	case txSelectEach:
+		var out [][]byte
+		for i, r := range records {
+			include, data, stop := op.selectFn(i, r)
+			if include {
+				out = append(out, data)
+			}
+			if stop {
+				out = append(out, records[i+1:]...)
+				break
+			}
+		}
+		return out
+	case txRemoveEach:
+		var out [][]byte
+		for i, r := range records {
+			remove, stop := op.removeFn(i, r)
+			if !remove {
+				out = append(out, r)
+			}
+			if stop {
+				out = append(out, records[i+1:]...)
+				break
+			}
+		}
+		return out
+	}
+	return records
+}
+
+// recoverIntentLogs finds any transaction intent logs left behind in
+// dir by a Commit that didn't finish, completing the rename sequence
+// for those with a commit marker and discarding the staged temp files
+// for those without one.
+func recoverIntentLogs(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), txIntentSuffix) {
+			continue
+		}
+		if err := recoverIntentLog(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recoverIntentLog(logPath string) error {
+	b, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	committed := len(lines) > 0 && lines[len(lines)-1] == "COMMIT"
+
+	var renames [][2]string
+	for _, line := range lines {
+		if line == "" || line == "COMMIT" {
+			continue
+		}
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		renames = append(renames, [2]string{parts[0], parts[1]})
+	}
+
+	for _, r := range renames {
+		tempPath, destPath := r[0], r[1]
+		if committed {
+			if _, err := os.Stat(tempPath); err == nil {
+				if err := os.Rename(tempPath, destPath); err != nil {
+					return err
+				}
+			}
+		} else {
+			os.Remove(tempPath)
+		}
+	}
+	return os.Remove(logPath)
+}
@@ -0,0 +1,94 @@
+package filedb_test
+
+import (
+	"testing"
+
+	"github.com/matryer/filedb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxCommit(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+
+	c1, err := db.C("TestTxCommitDB1")
+	require.NoError(t, err)
+	require.NoError(t, c1.Drop())
+	c2, err := db.C("TestTxCommitDB2")
+	require.NoError(t, err)
+	require.NoError(t, c2.Drop())
+
+	require.NoError(t, c1.InsertJSON(map[string]interface{}{"name": "Mat"}))
+	require.NoError(t, c1.InsertJSON(map[string]interface{}{"name": "Ryan"}))
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.RemoveEach(c1, func(i int, data []byte) (bool, bool) {
+		return i == 1, false // remove Ryan
+	}))
+	require.NoError(t, tx.Insert(c2, []byte(`{"name":"Tyler"}`)))
+	require.NoError(t, tx.Commit())
+
+	var c1Lines []string
+	require.NoError(t, c1.ForEach(func(i int, data []byte) bool {
+		c1Lines = append(c1Lines, string(data))
+		return false
+	}))
+	require.Equal(t, 1, len(c1Lines))
+
+	var c2Lines []string
+	require.NoError(t, c2.ForEach(func(i int, data []byte) bool {
+		c2Lines = append(c2Lines, string(data))
+		return false
+	}))
+	require.Equal(t, 1, len(c2Lines))
+
+	// Committing a second time should fail.
+	require.Error(t, tx.Commit())
+
+}
+
+func TestTxCommitRejectsIndexedCollection(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.C("TestTxCommitIndexedDB")
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Mat"}))
+	require.NoError(t, c.EnsureIndex("name", nameKeyFunc))
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Insert(c, []byte(`{"name":"Ryan"}`)))
+	require.Error(t, tx.Commit(), "Commit should reject a collection with an index registered")
+
+}
+
+func TestTxRollback(t *testing.T) {
+
+	db, err := filedb.Dial("test/db")
+	require.NoError(t, err)
+	defer db.Close()
+	c, err := db.C("TestTxRollbackDB")
+	require.NoError(t, err)
+	require.NoError(t, c.Drop())
+	require.NoError(t, c.InsertJSON(map[string]interface{}{"name": "Mat"}))
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Insert(c, []byte(`{"name":"Ryan"}`)))
+	tx.Rollback()
+
+	var lines []string
+	require.NoError(t, c.ForEach(func(i int, data []byte) bool {
+		lines = append(lines, string(data))
+		return false
+	}))
+	require.Equal(t, 1, len(lines), "rolled back insert should not be visible")
+
+}